@@ -3,19 +3,33 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/hashicorp/yamux"
 	"github.com/jpillora/backoff"
 	"k8s.io/klog"
+
+	"github.com/vizares/vizares-connect/internal/acl"
+	"github.com/vizares/vizares-connect/internal/metrics"
+	"github.com/vizares/vizares-connect/internal/mtls"
+	"github.com/vizares/vizares-connect/internal/netproxy"
+	"github.com/vizares/vizares-connect/internal/streamproto"
 )
 
 var (
@@ -27,23 +41,42 @@ var (
 	backoffMin               = 5 * time.Second
 	backoffMax               = time.Minute
 	streamTimeout            = 5 * time.Minute
+	reloadAckTimeout         = 30 * time.Second
 )
 
 type Tunnel struct {
-	address    string
-	serverName string
-	token      string
-	config     []byte
-	cancelFn   context.CancelFunc
-	gwConn     net.Conn
+	address     string
+	serverName  string
+	token       string
+	config      []byte
+	acl         *acl.Engine
+	proxyDialer netproxy.Dialer
+	clientCert  *mtls.ReloadingCertificate
+	caPool      *x509.CertPool
+	cancelFn    context.CancelFunc
+	gwConn      net.Conn
+	streamProto uint8
+
+	connected     chan struct{}
+	connectedOnce sync.Once
+
+	drain     chan struct{}
+	drainOnce sync.Once
+	retiring  int32
 }
 
-func NewTunnel(address, serverName string, token string, config []byte) *Tunnel {
+func NewTunnel(address, serverName string, token string, config []byte, aclEngine *acl.Engine, proxyDialer netproxy.Dialer, clientCert *mtls.ReloadingCertificate, caPool *x509.CertPool) *Tunnel {
 	t := &Tunnel{
-		address:    address,
-		serverName: serverName,
-		token:      token,
-		config:     config,
+		address:     address,
+		serverName:  serverName,
+		token:       token,
+		config:      config,
+		acl:         aclEngine,
+		proxyDialer: proxyDialer,
+		clientCert:  clientCert,
+		caPool:      caPool,
+		connected:   make(chan struct{}),
+		drain:       make(chan struct{}),
 	}
 	var ctx context.Context
 	ctx, t.cancelFn = context.WithCancel(context.Background())
@@ -59,16 +92,26 @@ func (t *Tunnel) keepConnected(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
-			t.gwConn, err = connect(t.address, t.serverName, t.token, t.config)
-			if err == nil {
+			t.gwConn, t.streamProto, err = connect(t.address, t.serverName, t.token, t.config, t.proxyDialer, t.clientCert, t.caPool)
+			if err != nil {
+				metrics.TunnelsTotal.WithLabelValues(t.address, "failed").Inc()
+			} else {
+				metrics.TunnelsTotal.WithLabelValues(t.address, "connected").Inc()
+				metrics.SetReady()
+				t.connectedOnce.Do(func() { close(t.connected) })
 				start := time.Now()
-				err = proxy(ctx, t.gwConn)
+				err = proxy(ctx, t.gwConn, t.acl, t.address, t.streamProto, t.drain)
 				_ = t.gwConn.Close()
+				metrics.TunnelsTotal.WithLabelValues(t.address, "disconnected").Inc()
 				if time.Since(start) > b.Max {
 					b.Reset()
 				}
 			}
+			if atomic.LoadInt32(&t.retiring) == 1 {
+				return
+			}
 			if err != nil {
+				metrics.ReconnectsTotal.WithLabelValues(t.address, reconnectReason(err)).Inc()
 				klog.Errorln(err)
 				d := b.Duration()
 				klog.Infof("reconnecting to %s in %.0fs", t.address, d.Seconds())
@@ -87,41 +130,191 @@ func (t *Tunnel) Close() {
 	}
 }
 
+// Drain retires the tunnel: the per-stream accept loop stops taking new
+// streams but lets the ones already open finish, up to streamTimeout,
+// before the gateway connection is closed. Used when a hot config reload
+// replaces this tunnel with one dialed from the new config.
+func (t *Tunnel) Drain() {
+	t.drainOnce.Do(func() {
+		atomic.StoreInt32(&t.retiring, 1)
+		close(t.drain)
+	})
+}
+
 func main() {
 	resolverUrl := os.Getenv("RESOLVER_URL")
 	if resolverUrl == "" {
 		resolverUrl = "https://gw.vizares.com/connect/resolve"
 	}
-	token := mustEnv("PROJECT_TOKEN")
-	if len(token) != 36 {
-		klog.Exitln("invalid project token")
+	clientCert, err := loadClientCertificate()
+	if err != nil {
+		klog.Exitln("failed to load client certificate:", err)
+	}
+
+	token := os.Getenv("PROJECT_TOKEN")
+	if clientCert == nil {
+		if len(token) != 36 {
+			klog.Exitln("invalid project token")
+		}
+	} else if token != "" {
+		klog.Exitln("PROJECT_TOKEN and CLIENT_CERT_PATH/CLIENT_KEY_PATH are mutually exclusive")
 	}
 	configPath := mustEnv("CONFIG_PATH")
 
-	data, err := os.ReadFile(configPath)
+	config, err := readConfig(configPath)
 	if err != nil {
 		klog.Exitln("failed to read config:", err)
 	}
-	config := []byte(os.ExpandEnv(string(data)))
+
+	aclEngine, err := loadACL(os.Getenv("ACL_PATH"))
+	if err != nil {
+		klog.Exitln("failed to load ACL:", err)
+	}
+
+	proxyDialer, err := netproxy.FromEnvironment()
+	if err != nil {
+		klog.Exitln("failed to configure egress proxy:", err)
+	}
+
+	var caPool *x509.CertPool
+	if caBundlePath := os.Getenv("CA_BUNDLE_PATH"); caBundlePath != "" {
+		caPool, err = mtls.LoadCABundle(caBundlePath)
+		if err != nil {
+			klog.Exitln("failed to load CA bundle:", err)
+		}
+	}
+
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	metrics.Serve(metricsAddr)
+
+	reload := make(chan struct{}, 1)
+	watchReloadSignals(configPath, reload)
 
 	klog.Infof("version: %s", version)
 
-	loop(token, resolverUrl, config)
+	loop(token, resolverUrl, configPath, config, aclEngine, proxyDialer, clientCert, caPool, reload)
+}
+
+// loadClientCertificate loads the mTLS client certificate from
+// CLIENT_CERT_PATH/CLIENT_KEY_PATH, if both are set, as an alternative to
+// authenticating with PROJECT_TOKEN. It returns a nil source when neither
+// is set.
+func loadClientCertificate() (*mtls.ReloadingCertificate, error) {
+	certPath := os.Getenv("CLIENT_CERT_PATH")
+	keyPath := os.Getenv("CLIENT_KEY_PATH")
+	if certPath == "" && keyPath == "" {
+		return nil, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, fmt.Errorf("CLIENT_CERT_PATH and CLIENT_KEY_PATH must both be set")
+	}
+	cert, err := mtls.LoadReloading(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("authenticating to the gateway with the client certificate at %s", certPath)
+	return cert, nil
+}
+
+// readConfig reads the config file at path and expands environment
+// variables in it, the same way main does on startup.
+func readConfig(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(os.ExpandEnv(string(data))), nil
+}
+
+// watchReloadSignals forwards SIGHUP and, best-effort, config file mtime
+// changes onto reload so loop can pick up a new CONFIG_PATH without a pod
+// restart. fsnotify failures are logged but non-fatal: SIGHUP alone is
+// enough to drive a reload.
+func watchReloadSignals(configPath string, reload chan<- struct{}) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			notify(reload)
+		}
+	}()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Errorf("failed to watch %s for changes: %s", configPath, err)
+		return
+	}
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		klog.Errorf("failed to watch %s for changes: %s", configPath, err)
+		_ = watcher.Close()
+		return
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(configPath) &&
+					(event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0) {
+					notify(reload)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.Errorf("error watching %s for changes: %s", configPath, err)
+			}
+		}
+	}()
+}
+
+// notify signals reload without blocking if a reload is already pending.
+func notify(reload chan<- struct{}) {
+	select {
+	case reload <- struct{}{}:
+	default:
+	}
+}
+
+// loadACL reads and parses the ACL config at aclPath, if set. With no path
+// configured it returns an engine that allows everything, preserving the
+// agent's historical behavior of dialing any destination the gateway asks
+// for.
+func loadACL(aclPath string) (*acl.Engine, error) {
+	if aclPath == "" {
+		return &acl.Engine{Default: acl.Allow}, nil
+	}
+	data, err := readConfig(aclPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL config: %s", err)
+	}
+	engine, err := acl.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ACL config: %s", err)
+	}
+	klog.Infof("loaded %d ACL rule(s) from %s, default %s", len(engine.Rules), aclPath, engine.Default)
+	return engine, nil
 }
 
-func loop(token, resolverUrl string, config []byte) {
+func loop(token, resolverUrl, configPath string, config []byte, aclEngine *acl.Engine, proxyDialer netproxy.Dialer, clientCert *mtls.ReloadingCertificate, caPool *x509.CertPool, reload <-chan struct{}) {
 	u, err := url.Parse(resolverUrl)
 	if err != nil {
 		klog.Exitf("invalid resolver URL %s: %s", resolverUrl, err)
 	}
 	tlsServerName := u.Hostname()
 
+	httpClient := &http.Client{Transport: netproxy.NewHTTPTransport(proxyDialer)}
 	tunnels := map[string]*Tunnel{}
 
 	b := backoff.Backoff{Factor: backoffFactor, Min: backoffMin, Max: backoffMax}
 	for {
 		klog.Infof("updating gateways endpoints from %s", resolverUrl)
-		endpoints, err := getEndpoints(resolverUrl, token)
+		endpoints, err := getEndpoints(httpClient, resolverUrl, token)
 		if err != nil {
 			d := b.Duration()
 			klog.Errorf("failed to get gateway endpoints: %s, retry in %.0fs", err, d.Seconds())
@@ -135,7 +328,7 @@ func loop(token, resolverUrl string, config []byte) {
 			fresh[e] = true
 			if _, ok := tunnels[e]; !ok {
 				klog.Infof("starting a tunnel to %s", e)
-				tunnels[e] = NewTunnel(e, tlsServerName, token, config)
+				tunnels[e] = NewTunnel(e, tlsServerName, token, config, aclEngine, proxyDialer, clientCert, caPool)
 			}
 		}
 		for e, t := range tunnels {
@@ -145,14 +338,51 @@ func loop(token, resolverUrl string, config []byte) {
 				delete(tunnels, e)
 			}
 		}
-		time.Sleep(endpointsRefreshInterval)
+
+		select {
+		case <-reload:
+			klog.Infof("reload signal received, re-reading %s", configPath)
+			newConfig, err := readConfig(configPath)
+			if err != nil {
+				klog.Errorf("failed to reload config from %s: %s", configPath, err)
+				continue
+			}
+			if string(newConfig) == string(config) {
+				klog.Infof("config at %s unchanged, nothing to reload", configPath)
+				continue
+			}
+			config = newConfig
+			hotSwapTunnels(tunnels, tlsServerName, token, config, aclEngine, proxyDialer, clientCert, caPool)
+			continue
+		case <-time.After(endpointsRefreshInterval):
+		}
 	}
 }
 
-func getEndpoints(resolverUrl, token string) ([]string, error) {
+// hotSwapTunnels replaces each running tunnel with one dialed against the
+// newly reloaded config, without dropping the in-flight streams on the
+// tunnel it replaces: it waits for the new tunnel to ack the handshake,
+// then drains the old one in the background.
+func hotSwapTunnels(tunnels map[string]*Tunnel, serverName, token string, config []byte, aclEngine *acl.Engine, proxyDialer netproxy.Dialer, clientCert *mtls.ReloadingCertificate, caPool *x509.CertPool) {
+	for address, old := range tunnels {
+		klog.Infof("re-establishing tunnel to %s with reloaded config", address)
+		next := NewTunnel(address, serverName, token, config, aclEngine, proxyDialer, clientCert, caPool)
+		select {
+		case <-next.connected:
+			klog.Infof("new tunnel to %s acked the reloaded config, draining old session", address)
+			tunnels[address] = next
+			old.Drain()
+		case <-time.After(reloadAckTimeout):
+			klog.Errorf("timed out waiting for %s to ack the reloaded config, keeping old tunnel", address)
+			next.Close()
+		}
+	}
+}
+
+func getEndpoints(httpClient *http.Client, resolverUrl, token string) ([]string, error) {
 	req, _ := http.NewRequest("GET", resolverUrl, nil)
 	req.Header.Set("X-Token", token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -167,53 +397,81 @@ func getEndpoints(resolverUrl, token string) ([]string, error) {
 	return strings.Split(strings.TrimSpace(string(payload)), ";"), nil
 }
 
+// Auth flag bits for RequestHeader.AuthFlags.
+const (
+	// authFlagMTLS indicates the agent authenticated this connection with
+	// a client certificate, so the gateway should authorize the peer
+	// certificate's SAN/CN instead of the (zero-filled) Token field.
+	authFlagMTLS uint8 = 1 << 0
+)
+
 type RequestHeader struct {
-	Token      [36]byte
-	Version    [16]byte
-	ConfigSize uint32
+	Token             [36]byte
+	Version           [16]byte
+	ConfigSize        uint32
+	StreamProtocolMax uint8
+	AuthFlags         uint8
 }
 
 type ResponseHeader struct {
-	Status      uint16
-	MessageSize uint16
+	Status                uint16
+	MessageSize           uint16
+	StreamProtocolVersion uint8
 }
 
-func connect(gwAddr, serverName, token string, config []byte) (net.Conn, error) {
+func connect(gwAddr, serverName, token string, config []byte, proxyDialer netproxy.Dialer, clientCert *mtls.ReloadingCertificate, caPool *x509.CertPool) (net.Conn, uint8, error) {
 	requestHeader := RequestHeader{}
-	copy(requestHeader.Token[:], token)
+	if clientCert == nil {
+		copy(requestHeader.Token[:], token)
+	} else {
+		requestHeader.AuthFlags |= authFlagMTLS
+	}
 	copy(requestHeader.Version[:], version)
 	requestHeader.ConfigSize = uint32(len(config))
+	requestHeader.StreamProtocolMax = streamproto.MaxSupportedVersion
 
 	klog.Infof("connecting to %s (%s)", gwAddr, serverName)
-	deadline := time.Now().Add(timeout)
-	dialer := &net.Dialer{Deadline: deadline}
-	tlsCfg := &tls.Config{ServerName: serverName, InsecureSkipVerify: tlsSkipVerify}
-	gwConn, err := tls.DialWithDialer(dialer, "tcp", gwAddr, tlsCfg)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rawConn, err := proxyDialer.DialContext(ctx, "tcp", gwAddr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to establish a connection to %s: %s", gwAddr, err)
+		return nil, 0, fmt.Errorf("failed to establish a connection to %s: %s", gwAddr, err)
+	}
+	deadline := time.Now().Add(timeout)
+	_ = rawConn.SetDeadline(deadline)
+	// ServerName must stay the gateway's hostname even when we dialed
+	// through a proxy, since the proxy is transparent to the TLS handshake.
+	tlsCfg := &tls.Config{ServerName: serverName, InsecureSkipVerify: tlsSkipVerify, RootCAs: caPool}
+	if clientCert != nil {
+		tlsCfg.GetClientCertificate = clientCert.GetClientCertificate
+	}
+	gwConn := tls.Client(rawConn, tlsCfg)
+	if err := gwConn.HandshakeContext(ctx); err != nil {
+		_ = rawConn.Close()
+		return nil, 0, fmt.Errorf("failed to establish a connection to %s: %s", gwAddr, err)
 	}
 	klog.Infof("connected to gateway %s", gwAddr)
 
 	_ = gwConn.SetDeadline(deadline)
 	if err = binary.Write(gwConn, binary.LittleEndian, requestHeader); err != nil {
 		_ = gwConn.Close()
-		return nil, fmt.Errorf("failed to send config to %s: %s", gwAddr, err)
+		return nil, 0, fmt.Errorf("failed to send config to %s: %s", gwAddr, err)
 	}
 	if _, err = gwConn.Write(config); err != nil {
 		_ = gwConn.Close()
-		return nil, fmt.Errorf("failed to send config to %s: %s", gwAddr, err)
+		return nil, 0, fmt.Errorf("failed to send config to %s: %s", gwAddr, err)
 	}
 	var responseHeader ResponseHeader
 	if err := binary.Read(gwConn, binary.LittleEndian, &responseHeader); err != nil {
 		_ = gwConn.Close()
-		return nil, fmt.Errorf("failed to read the response from %s: %s", gwAddr, err)
+		return nil, 0, fmt.Errorf("failed to read the response from %s: %s", gwAddr, err)
 	}
 	var responseMessage string
 	if responseHeader.MessageSize > 0 {
 		buf := make([]byte, responseHeader.MessageSize)
 		if _, err := gwConn.Read(buf); err != nil {
 			_ = gwConn.Close()
-			return nil, fmt.Errorf("failed to read the response from %s: %s", gwAddr, err)
+			return nil, 0, fmt.Errorf("failed to read the response from %s: %s", gwAddr, err)
 		}
 		responseMessage = string(buf)
 	}
@@ -221,13 +479,24 @@ func connect(gwAddr, serverName, token string, config []byte) (net.Conn, error)
 
 	if responseHeader.Status != 200 {
 		_ = gwConn.Close()
-		return nil, fmt.Errorf("got %d from %s: %s", responseHeader.Status, gwAddr, responseMessage)
+		return nil, 0, fmt.Errorf("got %d from %s: %s", responseHeader.Status, gwAddr, responseMessage)
 	}
-	klog.Infof("ready to proxy requests from %s", gwAddr)
-	return gwConn, nil
+	streamProto := responseHeader.StreamProtocolVersion
+	if streamProto == 0 {
+		streamProto = streamproto.Version1
+	}
+	klog.Infof("ready to proxy requests from %s (stream protocol v%d)", gwAddr, streamProto)
+	return gwConn, streamProto, nil
 }
 
-func proxy(ctx context.Context, gwConn net.Conn) error {
+// acceptResult is the result of one session.Accept() call, shuttled over a
+// channel so proxy's main loop can select on it alongside ctx and drain.
+type acceptResult struct {
+	stream net.Conn
+	err    error
+}
+
+func proxy(ctx context.Context, gwConn net.Conn, aclEngine *acl.Engine, endpoint string, streamProto uint8, drain <-chan struct{}) error {
 	cfg := yamux.DefaultConfig()
 	cfg.KeepAliveInterval = time.Second
 	cfg.LogOutput = io.Discard
@@ -236,36 +505,130 @@ func proxy(ctx context.Context, gwConn net.Conn) error {
 		return fmt.Errorf("failed to start a TCP multiplexing server: %s", err)
 	}
 	defer session.Close()
+
+	// stop is closed when proxy returns, so the accept goroutine can always
+	// exit, even after proxy's main loop has already returned
+	// (ctx.Done/drain) and stopped reading from accepted; without this the
+	// deferred session.Close() above unblocks Accept() but the goroutine
+	// then blocks forever trying to send with nobody left to receive.
+	stop := make(chan struct{})
+	defer close(stop)
+	accepted := make(chan acceptResult, 1)
+	go func() {
+		for {
+			s, err := session.Accept()
+			select {
+			case accepted <- acceptResult{stream: s, err: err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var streams sync.WaitGroup
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		default:
-			gwStream, err := session.Accept()
-			if err != nil {
-				return fmt.Errorf("failed to accept a stream: %s", err)
+		case <-drain:
+			klog.Infof("draining tunnel to %s, waiting up to %s for in-flight streams", endpoint, streamTimeout)
+			done := make(chan struct{})
+			go func() {
+				streams.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(streamTimeout):
+				klog.Warningf("stream drain on %s timed out after %s, closing anyway", endpoint, streamTimeout)
+			}
+			return nil
+		case res := <-accepted:
+			if res.err != nil {
+				return fmt.Errorf("failed to accept a stream: %s", res.err)
 			}
+			metrics.StreamsOpen.WithLabelValues(endpoint).Inc()
+			streams.Add(1)
 			go func(c net.Conn) {
-				defer c.Close()
+				streamStart := time.Now()
+				defer func() {
+					c.Close()
+					metrics.StreamsOpen.WithLabelValues(endpoint).Dec()
+					metrics.StreamDuration.WithLabelValues(endpoint).Observe(time.Since(streamStart).Seconds())
+					streams.Done()
+				}()
 				deadline := time.Now().Add(streamTimeout)
 				if err := c.SetDeadline(deadline); err != nil {
 					klog.Errorf("failed to set a deadline for the stream: %s", err)
 					return
 				}
-				var dstLen uint16
-				if err := binary.Read(c, binary.LittleEndian, &dstLen); err != nil {
-					klog.Errorf("failed to read the destination size: %s", err)
-					return
+
+				var destAddress string
+				var err error
+				connectTimeout := timeout
+				framed := streamProto >= streamproto.Version2
+				if framed {
+					var reqHeader streamproto.RequestHeader
+					reqHeader, destAddress, err = streamproto.ReadRequestV2(c)
+					if err != nil {
+						klog.Errorf("%s", err)
+						return
+					}
+					if reqHeader.IdleTimeoutMs > 0 {
+						deadline = time.Now().Add(time.Duration(reqHeader.IdleTimeoutMs) * time.Millisecond)
+						_ = c.SetDeadline(deadline)
+					}
+					if reqHeader.ConnectTimeoutMs > 0 {
+						connectTimeout = time.Duration(reqHeader.ConnectTimeoutMs) * time.Millisecond
+					}
+				} else {
+					destAddress, err = streamproto.ReadDestinationV1(c)
+					if err != nil {
+						klog.Errorf("%s", err)
+						return
+					}
 				}
-				dest := make([]byte, int(dstLen))
-				if _, err := io.ReadFull(c, dest); err != nil {
-					klog.Errorf("failed to read the destination address: %s", err)
-					return
+
+				// fail logs logMsg (at Warning for an expected ACL denial, at
+				// Error for everything else), bumps the dial failure metric
+				// when dialClass is set, and writes a v2 status response.
+				fail := func(status uint16, dialClass string, warn bool, logMsg string) {
+					if warn {
+						klog.Warningf("%s", logMsg)
+					} else {
+						klog.Errorf("%s", logMsg)
+					}
+					if dialClass != "" {
+						metrics.DialFailuresTotal.WithLabelValues(dialClass).Inc()
+					}
+					if framed {
+						if err := streamproto.WriteResponseV2(c, status, logMsg); err != nil {
+							klog.Errorf("failed to write stream response: %s", err)
+						}
+					}
+				}
+
+				if aclEngine != nil {
+					action, rule, err := aclEngine.Check(destAddress)
+					if err != nil {
+						fail(streamproto.StatusBadGateway, "acl_error", false, fmt.Sprintf("failed to evaluate ACL for %s: %s", destAddress, err))
+						return
+					}
+					if action == acl.Deny {
+						fail(streamproto.StatusForbidden, "acl_denied", true, fmt.Sprintf("ACL denied destination %s (rule: %q)", destAddress, ruleString(rule)))
+						return
+					}
 				}
-				destAddress := string(dest)
-				destConn, err := net.DialTimeout("tcp", destAddress, timeout)
+				destConn, err := net.DialTimeout("tcp", destAddress, connectTimeout)
 				if err != nil {
-					klog.Errorf("failed to establish a connection to %s: %s", destAddress, err)
+					status := streamproto.StatusBadGateway
+					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+						status = streamproto.StatusGatewayTimeout
+					}
+					fail(status, classifyDialError(err), false, fmt.Sprintf("failed to establish a connection to %s: %s", destAddress, err))
 					return
 				}
 				defer destConn.Close()
@@ -273,15 +636,65 @@ func proxy(ctx context.Context, gwConn net.Conn) error {
 					klog.Errorf("failed to set a deadline for the dest connection: %s", err)
 					return
 				}
+				if framed {
+					if err := streamproto.WriteResponseV2(c, streamproto.StatusOK, ""); err != nil {
+						klog.Errorf("failed to write stream response: %s", err)
+						return
+					}
+				}
 				go func() {
-					io.Copy(c, destConn)
+					n, _ := io.Copy(c, destConn)
+					metrics.StreamBytesTotal.WithLabelValues("down", endpoint).Add(float64(n))
 				}()
-				io.Copy(destConn, c)
-			}(gwStream)
+				n, _ := io.Copy(destConn, c)
+				metrics.StreamBytesTotal.WithLabelValues("up", endpoint).Add(float64(n))
+			}(res.stream)
 		}
 	}
 }
 
+// classifyDialError buckets a destination dial failure into a coarse class
+// for the vizares_connect_dial_failures_total label, without leaking raw
+// destination addresses into metric label values.
+func classifyDialError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	if strings.Contains(err.Error(), "connection refused") {
+		return "refused"
+	}
+	return "other"
+}
+
+// reconnectReason classifies why a tunnel session ended, for the
+// vizares_connect_reconnects_total reason label.
+func reconnectReason(err error) string {
+	if err == nil {
+		return "unknown"
+	}
+	switch {
+	case strings.Contains(err.Error(), "failed to establish a connection"):
+		return "gateway_unreachable"
+	case strings.Contains(err.Error(), "failed to accept a stream"):
+		return "session_closed"
+	default:
+		return "other"
+	}
+}
+
+// ruleString describes which ACL rule produced a verdict, for log messages.
+func ruleString(r *acl.Rule) string {
+	if r == nil {
+		return "default"
+	}
+	return r.Raw
+}
+
 func mustEnv(key string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fixedDialer is a netproxy.Dialer that ignores the requested address and
+// always dials the same test gateway, so NewTunnel can be pointed at a fake
+// listener without changing the address it reports to metrics.
+type fixedDialer struct {
+	target string
+}
+
+func (f *fixedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, f.target)
+}
+
+// selfSignedListener starts a TLS listener on 127.0.0.1 using an ephemeral
+// self-signed certificate, for standing in as a fake gateway.
+func selfSignedListener(t *testing.T) net.Listener {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-gateway"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %s", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("tls.Listen: %s", err)
+	}
+	return ln
+}
+
+// acceptAndAck accepts one connection on ln, reads the RequestHeader and
+// config, then writes back a ResponseHeader acking the handshake.
+func acceptAndAck(t *testing.T, ln net.Listener) {
+	t.Helper()
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var reqHeader RequestHeader
+	if err := binary.Read(conn, binary.LittleEndian, &reqHeader); err != nil {
+		t.Logf("fake gateway: failed to read request header: %s", err)
+		return
+	}
+	cfg := make([]byte, reqHeader.ConfigSize)
+	if _, err := conn.Read(cfg); err != nil && reqHeader.ConfigSize > 0 {
+		t.Logf("fake gateway: failed to read config: %s", err)
+		return
+	}
+	respHeader := ResponseHeader{Status: 200, StreamProtocolVersion: reqHeader.StreamProtocolMax}
+	if err := binary.Write(conn, binary.LittleEndian, respHeader); err != nil {
+		t.Logf("fake gateway: failed to write response header: %s", err)
+		return
+	}
+	// Keep the connection open for a second so the tunnel's proxy loop has
+	// something to read from while the test runs.
+	time.Sleep(time.Second)
+}
+
+func withFastTimeouts(t *testing.T) {
+	t.Helper()
+	origTimeout, origAckTimeout, origBackoffMin, origBackoffMax, origSkipVerify :=
+		timeout, reloadAckTimeout, backoffMin, backoffMax, tlsSkipVerify
+	timeout = 200 * time.Millisecond
+	reloadAckTimeout = 200 * time.Millisecond
+	backoffMin = 10 * time.Millisecond
+	backoffMax = 20 * time.Millisecond
+	tlsSkipVerify = true
+	t.Cleanup(func() {
+		timeout, reloadAckTimeout, backoffMin, backoffMax, tlsSkipVerify =
+			origTimeout, origAckTimeout, origBackoffMin, origBackoffMax, origSkipVerify
+	})
+}
+
+func TestHotSwapTunnelsAcksAndDrainsOldTunnel(t *testing.T) {
+	withFastTimeouts(t)
+
+	ln := selfSignedListener(t)
+	defer ln.Close()
+	go acceptAndAck(t, ln)
+	go acceptAndAck(t, ln)
+
+	dialer := &fixedDialer{target: ln.Addr().String()}
+	old := NewTunnel("gw.example.com:443", "test-gateway", "token", nil, nil, dialer, nil, nil)
+	defer old.Close()
+	select {
+	case <-old.connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("initial tunnel never connected")
+	}
+
+	tunnels := map[string]*Tunnel{"gw.example.com:443": old}
+	hotSwapTunnels(tunnels, "test-gateway", "token", []byte("new-config"), nil, dialer, nil, nil)
+
+	next := tunnels["gw.example.com:443"]
+	if next == old {
+		t.Fatal("hotSwapTunnels did not replace the tunnel after the new one acked")
+	}
+	if atomic.LoadInt32(&old.retiring) != 1 {
+		t.Error("old tunnel was not marked retiring after a successful hot swap")
+	}
+	next.Close()
+}
+
+func TestHotSwapTunnelsKeepsOldTunnelOnAckTimeout(t *testing.T) {
+	withFastTimeouts(t)
+
+	// A dialer pointed at an address nothing is listening on, so the
+	// replacement tunnel never acks within reloadAckTimeout.
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve an address: %s", err)
+	}
+	addr := unreachable.Addr().String()
+	unreachable.Close()
+
+	dialer := &fixedDialer{target: addr}
+	old := NewTunnel("gw.example.com:443", "test-gateway", "token", nil, nil, dialer, nil, nil)
+	defer old.Close()
+
+	tunnels := map[string]*Tunnel{"gw.example.com:443": old}
+	hotSwapTunnels(tunnels, "test-gateway", "token", []byte("new-config"), nil, dialer, nil, nil)
+
+	if tunnels["gw.example.com:443"] != old {
+		t.Error("hotSwapTunnels replaced the tunnel even though the new one never acked")
+	}
+	if atomic.LoadInt32(&old.retiring) != 0 {
+		t.Error("old tunnel should not be retiring when the hot swap times out")
+	}
+}
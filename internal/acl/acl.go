@@ -0,0 +1,203 @@
+// Package acl implements a destination allowlist/denylist for the streams
+// the gateway asks the agent to proxy. Rules are loaded from a small
+// line-oriented config (see Parse) so operators can restrict which hosts,
+// CIDRs and ports a gateway is permitted to reach inside the customer's
+// network.
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Action is the verdict a Rule applies to a matching destination.
+type Action int
+
+const (
+	Deny Action = iota
+	Allow
+)
+
+func (a Action) String() string {
+	if a == Allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// portRange is an inclusive [lo, hi] range of TCP ports. A rule with no
+// explicit ports matches any port.
+type portRange struct {
+	lo, hi uint16
+}
+
+func (r portRange) contains(port uint16) bool {
+	return port >= r.lo && port <= r.hi
+}
+
+// Rule is a single allow/deny line: it matches a destination when both the
+// host and the port fall within its patterns. hits counts how many times
+// the rule decided a destination, for operator auditing.
+type Rule struct {
+	Action Action
+	Raw    string
+
+	hostGlob string
+	cidr     *net.IPNet
+	ports    []portRange
+
+	hits uint64
+}
+
+// Hits returns the number of destinations this rule has matched so far.
+func (r *Rule) Hits() uint64 {
+	return atomic.LoadUint64(&r.hits)
+}
+
+func (r *Rule) matchesHost(host string) bool {
+	if r.cidr != nil {
+		ip := net.ParseIP(host)
+		return ip != nil && r.cidr.Contains(ip)
+	}
+	ok, err := path.Match(r.hostGlob, host)
+	return err == nil && ok
+}
+
+func (r *Rule) matchesPort(port uint16) bool {
+	if len(r.ports) == 0 {
+		return true
+	}
+	for _, pr := range r.ports {
+		if pr.contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Engine is a compiled set of rules plus a default action applied when no
+// rule matches a destination.
+type Engine struct {
+	Default Action
+	Rules   []*Rule
+}
+
+// Check evaluates destAddress (host:port) against the engine's rules in
+// order and returns the resulting action and the rule that produced it, or
+// nil if the default action applied.
+func (e *Engine) Check(destAddress string) (Action, *Rule, error) {
+	host, portStr, err := net.SplitHostPort(destAddress)
+	if err != nil {
+		return Deny, nil, fmt.Errorf("invalid destination %q: %s", destAddress, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return Deny, nil, fmt.Errorf("invalid destination port %q: %s", portStr, err)
+	}
+	for _, r := range e.Rules {
+		if r.matchesHost(host) && r.matchesPort(uint16(port)) {
+			atomic.AddUint64(&r.hits, 1)
+			return r.Action, r, nil
+		}
+	}
+	return e.Default, nil, nil
+}
+
+// Parse reads an ACL config of the form:
+//
+//	default allow|deny
+//	allow <host-glob-or-cidr> [port-ranges]
+//	deny  <host-glob-or-cidr> [port-ranges]
+//
+// where port-ranges is a comma separated list of "n" or "n-m", or "*" (the
+// default) to match any port. Blank lines and lines starting with '#' are
+// ignored. Rules are evaluated top to bottom; the first match wins.
+func Parse(data []byte) (*Engine, error) {
+	e := &Engine{Default: Deny}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch strings.ToLower(fields[0]) {
+		case "default":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("acl line %d: expected %q, got %q", lineNo, "default allow|deny", line)
+			}
+			action, err := parseAction(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("acl line %d: %s", lineNo, err)
+			}
+			e.Default = action
+		case "allow", "deny":
+			if len(fields) < 2 || len(fields) > 3 {
+				return nil, fmt.Errorf("acl line %d: expected %q, got %q", lineNo, "allow|deny <host> [ports]", line)
+			}
+			action, _ := parseAction(fields[0])
+			rule := &Rule{Action: action, Raw: line}
+			if strings.Contains(fields[1], "/") {
+				_, cidr, err := net.ParseCIDR(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("acl line %d: invalid CIDR %q: %s", lineNo, fields[1], err)
+				}
+				rule.cidr = cidr
+			} else {
+				rule.hostGlob = fields[1]
+			}
+			if len(fields) == 3 && fields[2] != "*" {
+				ports, err := parsePorts(fields[2])
+				if err != nil {
+					return nil, fmt.Errorf("acl line %d: %s", lineNo, err)
+				}
+				rule.ports = ports
+			}
+			e.Rules = append(e.Rules, rule)
+		default:
+			return nil, fmt.Errorf("acl line %d: unknown directive %q", lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func parseAction(s string) (Action, error) {
+	switch strings.ToLower(s) {
+	case "allow":
+		return Allow, nil
+	case "deny":
+		return Deny, nil
+	default:
+		return Deny, fmt.Errorf("unknown action %q", s)
+	}
+}
+
+func parsePorts(s string) ([]portRange, error) {
+	var ranges []portRange
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, ok := strings.Cut(part, "-")
+		loPort, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %s", lo, err)
+		}
+		hiPort := loPort
+		if ok {
+			hiPort, err = strconv.ParseUint(hi, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q: %s", hi, err)
+			}
+		}
+		ranges = append(ranges, portRange{lo: uint16(loPort), hi: uint16(hiPort)})
+	}
+	return ranges, nil
+}
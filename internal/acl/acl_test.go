@@ -0,0 +1,90 @@
+package acl
+
+import "testing"
+
+func TestParseAndCheck(t *testing.T) {
+	cfg := `
+# comment
+default deny
+
+allow 10.0.0.0/8
+allow *.internal.example.com 443,8443
+deny  *.internal.example.com
+allow metrics.example.com 9000-9100
+`
+	e, err := Parse([]byte(cfg))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		dest string
+		want Action
+	}{
+		{"cidr match", "10.1.2.3:22", Allow},
+		{"cidr match another address", "10.2.3.4:22", Allow}, // still in 10.0.0.0/8
+		{"outside cidr falls to default", "8.8.8.8:53", Deny},
+		{"host glob with allowed port", "db.internal.example.com:443", Allow},
+		{"host glob with denied port", "db.internal.example.com:80", Deny},
+		{"port range match", "metrics.example.com:9050", Allow},
+		{"port range miss", "metrics.example.com:9200", Deny},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, _, err := e.Check(tc.dest)
+			if err != nil {
+				t.Fatalf("Check(%q): %s", tc.dest, err)
+			}
+			if got != tc.want {
+				t.Errorf("Check(%q) = %s, want %s", tc.dest, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckInvalidDestination(t *testing.T) {
+	e := &Engine{Default: Allow}
+	if _, _, err := e.Check("not-a-host-port"); err == nil {
+		t.Fatal("expected an error for a destination without a port")
+	}
+	if _, _, err := e.Check("host:not-a-port"); err == nil {
+		t.Fatal("expected an error for a non-numeric port")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{"bad default", "default maybe\n"},
+		{"bad directive", "permit 1.2.3.4\n"},
+		{"bad cidr", "allow 10.0.0.0/40\n"},
+		{"bad port", "allow host.example.com abc\n"},
+		{"missing host", "allow\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tc.cfg)); err == nil {
+				t.Fatalf("Parse(%q) expected an error, got none", tc.cfg)
+			}
+		})
+	}
+}
+
+func TestRuleHits(t *testing.T) {
+	e, err := Parse([]byte("default deny\nallow 1.2.3.4/32\n"))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if _, _, err := e.Check("1.2.3.4:80"); err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	if _, _, err := e.Check("1.2.3.4:443"); err != nil {
+		t.Fatalf("Check: %s", err)
+	}
+	if got := e.Rules[0].Hits(); got != 2 {
+		t.Errorf("Hits() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,101 @@
+// Package metrics exposes Prometheus metrics and health/readiness probes
+// for the connect-agent, so operators can observe tunnel state and wire up
+// a Kubernetes liveness/readiness check.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog"
+)
+
+var (
+	TunnelsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vizares_connect_tunnels_total",
+		Help: "Tunnel lifecycle events, by endpoint and resulting state.",
+	}, []string{"endpoint", "state"})
+
+	ReconnectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vizares_connect_reconnects_total",
+		Help: "Tunnel reconnect attempts, by endpoint and the reason the previous session ended.",
+	}, []string{"endpoint", "reason"})
+
+	StreamsOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vizares_connect_streams_open",
+		Help: "Number of proxied streams currently open, by endpoint.",
+	}, []string{"endpoint"})
+
+	StreamBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vizares_connect_stream_bytes_total",
+		Help: "Bytes copied over proxied streams, by direction (\"up\"/\"down\") and endpoint.",
+	}, []string{"direction", "endpoint"})
+
+	DialFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vizares_connect_dial_failures_total",
+		Help: "Destination dial failures, by a coarse classification of the cause.",
+	}, []string{"destination_class"})
+
+	StreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vizares_connect_stream_duration_seconds",
+		Help:    "Duration of proxied streams from accept to close.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"endpoint"})
+)
+
+// ready flips to 1 once at least one tunnel has reached the "ready to
+// proxy" state, so /readyz can tell a fully-failing agent apart from one
+// that is still starting up.
+var ready int32
+
+// SetReady marks the agent as having at least one tunnel that reached the
+// "ready to proxy" state with a gateway endpoint.
+func SetReady() {
+	atomic.StoreInt32(&ready, 1)
+}
+
+// IsReady reports whether SetReady has been called.
+func IsReady() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
+
+// handler builds the /metrics, /healthz and /readyz mux, split out from
+// Serve so tests can exercise the routing and readiness gating without
+// binding a real listener.
+func handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !IsReady() {
+			http.Error(w, "no tunnel has reached ready state yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// Serve starts an HTTP server on addr exposing /metrics, /healthz and
+// /readyz. It returns immediately; callers should Shutdown the returned
+// server on exit.
+func Serve(addr string) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: handler()}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("metrics server on %s exited: %s", addr, err)
+		}
+	}()
+	return srv
+}
+
+// Shutdown gracefully stops srv, bounded by ctx.
+func Shutdown(ctx context.Context, srv *http.Server) {
+	_ = srv.Shutdown(ctx)
+}
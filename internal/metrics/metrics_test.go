@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyzGatesOnIsReady(t *testing.T) {
+	srv := httptest.NewServer(handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before SetReady: status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	SetReady()
+	resp, err = http.Get(srv.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/readyz after SetReady: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !IsReady() {
+		t.Error("IsReady() = false after SetReady()")
+	}
+}
+
+func TestHealthzAlwaysOK(t *testing.T) {
+	srv := httptest.NewServer(handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/healthz: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,107 @@
+// Package mtls lets the agent authenticate to the gateway with an X.509
+// client certificate instead of the 36-byte PROJECT_TOKEN, so operators can
+// rotate credentials with cert-manager rather than baking a token into a
+// Secret.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ReloadingCertificate serves a client certificate out of two files,
+// reloading them from disk whenever either one's mtime changes. This
+// covers the common cert-manager rotation path without needing a watcher
+// goroutine: the certificate is only re-read on the next handshake.
+type ReloadingCertificate struct {
+	certPath, keyPath string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime int64
+	keyModTime  int64
+}
+
+// LoadReloading loads the certificate/key pair once to fail fast on a bad
+// path or malformed PEM, and returns a source that reloads them from disk
+// whenever the files change.
+func LoadReloading(certPath, keyPath string) (*ReloadingCertificate, error) {
+	r := &ReloadingCertificate{certPath: certPath, keyPath: keyPath}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (r *ReloadingCertificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if err := r.reloadIfChanged(); err != nil {
+		// Serve the last good certificate rather than failing the
+		// handshake over a transient read error during a cert rotation.
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+func (r *ReloadingCertificate) reloadIfChanged() error {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", r.certPath, err)
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", r.keyPath, err)
+	}
+	r.mu.Lock()
+	unchanged := certInfo.ModTime().UnixNano() == r.certModTime && keyInfo.ModTime().UnixNano() == r.keyModTime
+	r.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	return r.reload()
+}
+
+func (r *ReloadingCertificate) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate: %s", err)
+	}
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", r.certPath, err)
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", r.keyPath, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime().UnixNano()
+	r.keyModTime = keyInfo.ModTime().UnixNano()
+	return nil
+}
+
+// LoadCABundle reads a PEM bundle of CA certificates from path, for
+// pinning the gateway's CA instead of trusting the system roots.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,132 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert generates a fresh self-signed cert/key pair with the given
+// serial number and writes them as PEM to certPath/keyPath.
+func writeCert(t *testing.T, certPath, keyPath string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %s", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+		t.Fatalf("write cert: %s", err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %s", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatalf("write key: %s", err)
+	}
+}
+
+func TestLoadReloadingServesCurrentCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	writeCert(t, certPath, keyPath, 1)
+
+	r, err := LoadReloading(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("LoadReloading: %s", err)
+	}
+	cert, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetClientCertificate: %s", err)
+	}
+	if len(cert.Certificate) == 0 {
+		t.Fatal("expected a non-empty certificate chain")
+	}
+}
+
+func TestLoadReloadingPicksUpRotatedCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	writeCert(t, certPath, keyPath, 1)
+
+	r, err := LoadReloading(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("LoadReloading: %s", err)
+	}
+	first, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetClientCertificate: %s", err)
+	}
+
+	writeCert(t, certPath, keyPath, 2)
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certPath, future, future); err != nil {
+		t.Fatalf("Chtimes cert: %s", err)
+	}
+	if err := os.Chtimes(keyPath, future, future); err != nil {
+		t.Fatalf("Chtimes key: %s", err)
+	}
+
+	second, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetClientCertificate after rotation: %s", err)
+	}
+	if bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Error("expected the reloaded certificate to differ from the original")
+	}
+}
+
+func TestLoadReloadingBadPath(t *testing.T) {
+	if _, err := LoadReloading("/nonexistent/tls.crt", "/nonexistent/tls.key"); err == nil {
+		t.Fatal("expected an error for a missing certificate path")
+	}
+}
+
+func TestLoadCABundle(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.crt")
+	keyPath := filepath.Join(dir, "ca.key")
+	writeCert(t, certPath, keyPath, 1)
+
+	pool, err := LoadCABundle(certPath)
+	if err != nil {
+		t.Fatalf("LoadCABundle: %s", err)
+	}
+	if pool == nil {
+		t.Fatal("expected a non-nil cert pool")
+	}
+}
+
+func TestLoadCABundleInvalid(t *testing.T) {
+	dir := t.TempDir()
+	badPath := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(badPath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("write bad bundle: %s", err)
+	}
+	if _, err := LoadCABundle(badPath); err == nil {
+		t.Fatal("expected an error for a bundle with no certificates")
+	}
+}
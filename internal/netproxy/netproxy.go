@@ -0,0 +1,181 @@
+// Package netproxy lets the agent egress through a corporate HTTP CONNECT
+// or SOCKS5 proxy when it cannot open a direct connection to the gateway.
+// It honors the conventional HTTPS_PROXY/ALL_PROXY/NO_PROXY environment
+// variables and exposes a single Dialer so both the resolver's HTTP calls
+// and the raw TCP tunnel dial through the same configuration.
+package netproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dialer dials a network address, optionally via a proxy.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+type direct struct {
+	net.Dialer
+}
+
+func (d *direct) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.Dialer.DialContext(ctx, network, addr)
+}
+
+// envDialer picks between a direct dial and a proxied dial per-address,
+// honoring NO_PROXY.
+type envDialer struct {
+	proxyURL *url.URL
+	noProxy  []string
+	direct   Dialer
+	proxied  Dialer
+}
+
+// FromEnvironment builds a Dialer from HTTPS_PROXY/https_proxy, falling
+// back to ALL_PROXY/all_proxy, and honoring NO_PROXY/no_proxy. It returns a
+// plain direct dialer if neither variable is set.
+func FromEnvironment() (Dialer, error) {
+	raw := firstNonEmpty("HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy")
+	d := &direct{}
+	if raw == "" {
+		return d, nil
+	}
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %s", raw, err)
+	}
+	proxied, err := dialerForURL(proxyURL, d)
+	if err != nil {
+		return nil, err
+	}
+	noProxy := splitAndTrim(firstNonEmpty("NO_PROXY", "no_proxy"))
+	return &envDialer{proxyURL: proxyURL, noProxy: noProxy, direct: d, proxied: proxied}, nil
+}
+
+func (e *envDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if matchesNoProxy(host, e.noProxy) {
+		return e.direct.DialContext(ctx, network, addr)
+	}
+	return e.proxied.DialContext(ctx, network, addr)
+}
+
+func dialerForURL(proxyURL *url.URL, forward Dialer) (Dialer, error) {
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(proxyURL, forward.(proxy.Dialer))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOCKS5 proxy %q: %s", proxyURL, err)
+		}
+		return &contextDialerAdapter{d}, nil
+	case "http", "https", "":
+		return &httpConnectDialer{proxyURL: proxyURL, forward: forward}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// contextDialerAdapter adapts the blocking proxy.Dialer interface from
+// golang.org/x/net/proxy to our context-aware Dialer.
+type contextDialerAdapter struct {
+	d proxy.Dialer
+}
+
+func (a *contextDialerAdapter) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if cd, ok := a.d.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+	return a.d.Dial(network, addr)
+}
+
+// httpConnectDialer tunnels through an HTTP proxy with a CONNECT request,
+// optionally authenticating with Basic auth carried in the proxy URL's
+// userinfo.
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  Dialer
+}
+
+func (h *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := h.forward.DialContext(ctx, network, h.proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %s", h.proxyURL.Host, err)
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if h.proxyURL.User != nil {
+		password, _ := h.proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(h.proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to write CONNECT request to %s: %s", h.proxyURL.Host, err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from %s: %s", h.proxyURL.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", h.proxyURL.Host, addr, resp.Status)
+	}
+	return conn, nil
+}
+
+// NewHTTPTransport returns an *http.Transport that dials through d, so the
+// resolver's HTTP client and the raw tunnel dial share one proxy config.
+func NewHTTPTransport(d Dialer) *http.Transport {
+	return &http.Transport{DialContext: d.DialContext}
+}
+
+func matchesNoProxy(host string, noProxy []string) bool {
+	for _, entry := range noProxy {
+		if entry == "" {
+			continue
+		}
+		if entry == "*" || host == entry || (strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitAndTrim(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return parts
+}
+
+func firstNonEmpty(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
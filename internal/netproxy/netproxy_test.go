@@ -0,0 +1,131 @@
+package netproxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestMatchesNoProxy(t *testing.T) {
+	noProxy := []string{"example.com", ".internal.example.com", "", "10.0.0.1"}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"sub.example.com", false},
+		{"db.internal.example.com", true},
+		{"internal.example.com", false},
+		{"10.0.0.1", true},
+		{"other.com", false},
+	}
+	for _, tc := range tests {
+		if got := matchesNoProxy(tc.host, noProxy); got != tc.want {
+			t.Errorf("matchesNoProxy(%q, %v) = %v, want %v", tc.host, noProxy, got, tc.want)
+		}
+	}
+}
+
+func TestMatchesNoProxyWildcard(t *testing.T) {
+	if !matchesNoProxy("anything.example.com", []string{"*"}) {
+		t.Error("expected \"*\" to match any host")
+	}
+}
+
+func TestSplitAndTrim(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a,b,c", []string{"a", "b", "c"}},
+		{" a , b ,c", []string{"a", "b", "c"}},
+	}
+	for _, tc := range tests {
+		got := splitAndTrim(tc.in)
+		if len(got) != len(tc.want) {
+			t.Fatalf("splitAndTrim(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("splitAndTrim(%q)[%d] = %q, want %q", tc.in, i, got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestDialerForURLScheme(t *testing.T) {
+	tests := []struct {
+		scheme  string
+		wantErr bool
+	}{
+		{"http", false},
+		{"https", false},
+		{"", false},
+		{"socks5", false},
+		{"ftp", true},
+	}
+	for _, tc := range tests {
+		u := &url.URL{Scheme: tc.scheme, Host: "proxy.example.com:1080"}
+		_, err := dialerForURL(u, &direct{})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("dialerForURL(scheme=%q) error = %v, wantErr %v", tc.scheme, err, tc.wantErr)
+		}
+	}
+}
+
+// fakeConnectProxy accepts one connection, reads the CONNECT request up to
+// its blank line, replies with the given status, then closes the connection.
+func fakeConnectProxy(t *testing.T, status string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake proxy listener: %s", err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = conn.Write([]byte(status))
+	}()
+	return ln
+}
+
+func TestHTTPConnectDialerSuccess(t *testing.T) {
+	ln := fakeConnectProxy(t, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	defer ln.Close()
+
+	d := &httpConnectDialer{
+		proxyURL: &url.URL{Scheme: "http", Host: ln.Addr().String()},
+		forward:  &direct{},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "dest.example.com:443")
+	if err != nil {
+		t.Fatalf("DialContext: %s", err)
+	}
+	conn.Close()
+}
+
+func TestHTTPConnectDialerRefused(t *testing.T) {
+	ln := fakeConnectProxy(t, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+	defer ln.Close()
+
+	d := &httpConnectDialer{
+		proxyURL: &url.URL{Scheme: "http", Host: ln.Addr().String()},
+		forward:  &direct{},
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", "dest.example.com:443"); err == nil {
+		t.Fatal("expected an error when the proxy refuses the CONNECT")
+	}
+}
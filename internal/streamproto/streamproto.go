@@ -0,0 +1,101 @@
+// Package streamproto defines the wire format used on each yamux stream
+// between the gateway and the agent, once a destination has been
+// negotiated. Version 1 is the legacy raw framing (a bare destination
+// length and address, with no response); version 2 adds a typed request
+// header and a structured status response, mirroring the ResponseHeader
+// already used on the control channel, so the gateway can tell a dial
+// failure, an ACL denial and a timeout apart instead of seeing a bare EOF.
+package streamproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// Version1 is the legacy framing: a uint16 destination length followed
+	// by the destination address, with no response header on either path.
+	Version1 uint8 = 1
+	// Version2 adds RequestHeader/ResponseHeader framing around the
+	// destination and the dial result.
+	Version2 uint8 = 2
+
+	// MaxSupportedVersion is the highest stream protocol version this
+	// agent build can speak; it is advertised in the control handshake so
+	// the gateway can negotiate down for older agents.
+	MaxSupportedVersion = Version2
+)
+
+// Flag bits for RequestHeader.Flags.
+const (
+	FlagNone uint8 = 0
+)
+
+// Status codes carried in a version 2 ResponseHeader. These line up with
+// the HTTP codes operators already know, not because the stream is HTTP.
+const (
+	StatusOK             uint16 = 200
+	StatusForbidden      uint16 = 403
+	StatusBadGateway     uint16 = 502
+	StatusGatewayTimeout uint16 = 504
+)
+
+// RequestHeader is the version 2 per-stream request, sent by the gateway
+// before the destination address bytes.
+type RequestHeader struct {
+	Version          uint8
+	Flags            uint8
+	DstLen           uint16
+	ConnectTimeoutMs uint32
+	IdleTimeoutMs    uint32
+}
+
+// ResponseHeader is the version 2 per-stream response, sent by the agent
+// before the message bytes and, on StatusOK, before the proxied bytes.
+type ResponseHeader struct {
+	Status      uint16
+	MessageSize uint16
+}
+
+// ReadDestinationV1 reads a version 1 legacy frame: a uint16 length
+// followed by the destination address.
+func ReadDestinationV1(r io.Reader) (string, error) {
+	var dstLen uint16
+	if err := binary.Read(r, binary.LittleEndian, &dstLen); err != nil {
+		return "", fmt.Errorf("failed to read the destination size: %s", err)
+	}
+	dst := make([]byte, int(dstLen))
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return "", fmt.Errorf("failed to read the destination address: %s", err)
+	}
+	return string(dst), nil
+}
+
+// ReadRequestV2 reads a version 2 RequestHeader plus its destination
+// address bytes.
+func ReadRequestV2(r io.Reader) (RequestHeader, string, error) {
+	var h RequestHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return h, "", fmt.Errorf("failed to read the stream request header: %s", err)
+	}
+	dst := make([]byte, int(h.DstLen))
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return h, "", fmt.Errorf("failed to read the destination address: %s", err)
+	}
+	return h, string(dst), nil
+}
+
+// WriteResponseV2 writes a version 2 ResponseHeader and message.
+func WriteResponseV2(w io.Writer, status uint16, message string) error {
+	h := ResponseHeader{Status: status, MessageSize: uint16(len(message))}
+	if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+		return fmt.Errorf("failed to write the stream response header: %s", err)
+	}
+	if len(message) > 0 {
+		if _, err := w.Write([]byte(message)); err != nil {
+			return fmt.Errorf("failed to write the stream response message: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package streamproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadDestinationV1(t *testing.T) {
+	var buf bytes.Buffer
+	dst := "example.com:443"
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(len(dst)))
+	buf.WriteString(dst)
+
+	got, err := ReadDestinationV1(&buf)
+	if err != nil {
+		t.Fatalf("ReadDestinationV1: %s", err)
+	}
+	if got != dst {
+		t.Errorf("ReadDestinationV1() = %q, want %q", got, dst)
+	}
+}
+
+func TestReadRequestV2RoundTrip(t *testing.T) {
+	dst := "example.com:443"
+	h := RequestHeader{
+		Version:          Version2,
+		Flags:            FlagNone,
+		DstLen:           uint16(len(dst)),
+		ConnectTimeoutMs: 2500,
+		IdleTimeoutMs:    60000,
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, h); err != nil {
+		t.Fatalf("binary.Write: %s", err)
+	}
+	buf.WriteString(dst)
+
+	gotHeader, gotDst, err := ReadRequestV2(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequestV2: %s", err)
+	}
+	if gotHeader != h {
+		t.Errorf("ReadRequestV2() header = %+v, want %+v", gotHeader, h)
+	}
+	if gotDst != dst {
+		t.Errorf("ReadRequestV2() dest = %q, want %q", gotDst, dst)
+	}
+}
+
+func TestReadRequestV2Truncated(t *testing.T) {
+	h := RequestHeader{Version: Version2, DstLen: 10}
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, h)
+	buf.WriteString("short")
+
+	if _, _, err := ReadRequestV2(&buf); err == nil {
+		t.Fatal("expected an error when the destination bytes are truncated")
+	}
+}
+
+func TestWriteResponseV2(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteResponseV2(&buf, StatusForbidden, "denied by ACL"); err != nil {
+		t.Fatalf("WriteResponseV2: %s", err)
+	}
+	var h ResponseHeader
+	if err := binary.Read(&buf, binary.LittleEndian, &h); err != nil {
+		t.Fatalf("binary.Read: %s", err)
+	}
+	if h.Status != StatusForbidden {
+		t.Errorf("Status = %d, want %d", h.Status, StatusForbidden)
+	}
+	if int(h.MessageSize) != buf.Len() {
+		t.Errorf("MessageSize = %d, want %d remaining bytes", h.MessageSize, buf.Len())
+	}
+	if got := buf.String(); got != "denied by ACL" {
+		t.Errorf("message = %q, want %q", got, "denied by ACL")
+	}
+}